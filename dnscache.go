@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Bounded, sharded reverse-DNS cache with negative caching and async
+// resolution. Replaces the old unbounded map + synchronous net.LookupAddr
+// on the decode hot path: a cache miss now returns the raw IP immediately
+// and enqueues the PTR lookup in the background, so later records for the
+// same IP pick up the resolved name once a worker fills it in.
+//
+// The underlying bounded/sharded LRU lives in lru.go and is shared with the
+// GeoIP cache in enrich.go.
+
+const dnsCacheShards = 16
+
+type dnsCacheValue struct {
+	hostname string
+	negative bool
+	expires  time.Time
+}
+
+// dnsCache is the bounded, sharded reverse-DNS cache. Resolution runs on a
+// fixed pool of background workers fed by resolveQueue; pending dedupes
+// concurrent requests for the same IP so one slow PTR lookup doesn't queue
+// it N times.
+type dnsCache struct {
+	lru         *shardedLRU[dnsCacheValue]
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+
+	resolveQueue chan string
+	pending      sync.Map
+}
+
+func newDNSCache(cacheSize, workers int, positiveTTL, negativeTTL time.Duration) *dnsCache {
+	c := &dnsCache{
+		lru:          newShardedLRU[dnsCacheValue](dnsCacheShards, cacheSize),
+		positiveTTL:  positiveTTL,
+		negativeTTL:  negativeTTL,
+		resolveQueue: make(chan string, cacheSize),
+	}
+	for i := 0; i < workers; i++ {
+		go c.resolveWorker()
+	}
+	return c
+}
+
+// lookup never blocks on DNS: a hit returns the cached (possibly negative)
+// result, and a miss or expired entry enqueues a background resolution and
+// returns the raw IP for this record.
+func (c *dnsCache) lookup(ipAddr string) string {
+	if value, ok := c.lru.get(ipAddr); ok && time.Now().Before(value.expires) {
+		dnsCacheHitsTotal.Inc()
+		if value.negative {
+			return ipAddr
+		}
+		return value.hostname
+	}
+
+	dnsCacheMissesTotal.Inc()
+	c.enqueueResolve(ipAddr)
+	return ipAddr
+}
+
+func (c *dnsCache) enqueueResolve(ipAddr string) {
+	if _, alreadyQueued := c.pending.LoadOrStore(ipAddr, struct{}{}); alreadyQueued {
+		return
+	}
+	select {
+	case c.resolveQueue <- ipAddr:
+	default:
+		// Queue is full; drop the lookup rather than block the caller and
+		// let a later record retry it.
+		c.pending.Delete(ipAddr)
+	}
+}
+
+func (c *dnsCache) resolveWorker() {
+	for ipAddr := range c.resolveQueue {
+		hostnames, err := net.LookupAddr(ipAddr)
+
+		var value dnsCacheValue
+		if err != nil || len(hostnames) == 0 {
+			value = dnsCacheValue{negative: true, expires: time.Now().Add(c.negativeTTL)}
+		} else {
+			value = dnsCacheValue{hostname: hostnames[0], expires: time.Now().Add(c.positiveTTL)}
+		}
+
+		c.lru.set(ipAddr, value)
+		c.pending.Delete(ipAddr)
+	}
+}
+
+// fnv32 is the FNV-1a hash, used only to pick a shard - not for anything
+// security-sensitive.
+func fnv32(s string) uint32 {
+	const fnvPrime32 = 16777619
+	hash := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		hash ^= uint32(s[i])
+		hash *= fnvPrime32
+	}
+	return hash
+}
+
+// globalDNSCache backs lookUpWithCache. It is set to sensible defaults
+// here and reconfigured from flags in main before any packets are handled.
+var globalDNSCache = newDNSCache(65536, 4, 24*time.Hour, 5*time.Minute)
+
+func lookUpWithCache(ipAddr string) string {
+	return globalDNSCache.lookup(ipAddr)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDNSCacheLRUEviction(t *testing.T) {
+	shard := newLRUShard[dnsCacheValue](2)
+	shard.set("10.0.0.1", dnsCacheValue{hostname: "a"})
+	shard.set("10.0.0.2", dnsCacheValue{hostname: "b"})
+	shard.set("10.0.0.3", dnsCacheValue{hostname: "c"}) // evicts 10.0.0.1 (least recently used)
+
+	if _, ok := shard.get("10.0.0.1"); ok {
+		t.Fatal("10.0.0.1 should have been evicted")
+	}
+	if v, ok := shard.get("10.0.0.2"); !ok || v.hostname != "b" {
+		t.Fatalf("10.0.0.2 = (%+v, %v), want (hostname=b, true)", v, ok)
+	}
+	if v, ok := shard.get("10.0.0.3"); !ok || v.hostname != "c" {
+		t.Fatalf("10.0.0.3 = (%+v, %v), want (hostname=c, true)", v, ok)
+	}
+}
+
+func TestDNSCacheGetPromotesToFront(t *testing.T) {
+	shard := newLRUShard[dnsCacheValue](2)
+	shard.set("10.0.0.1", dnsCacheValue{hostname: "a"})
+	shard.set("10.0.0.2", dnsCacheValue{hostname: "b"})
+
+	shard.get("10.0.0.1") // touch 10.0.0.1 so 10.0.0.2 becomes the LRU entry
+	shard.set("10.0.0.3", dnsCacheValue{hostname: "c"})
+
+	if _, ok := shard.get("10.0.0.2"); ok {
+		t.Fatal("10.0.0.2 should have been evicted after 10.0.0.1 was touched")
+	}
+	if _, ok := shard.get("10.0.0.1"); !ok {
+		t.Fatal("10.0.0.1 should still be cached")
+	}
+}
+
+func TestDNSCacheSetUpdatesExisting(t *testing.T) {
+	shard := newLRUShard[dnsCacheValue](2)
+	shard.set("10.0.0.1", dnsCacheValue{hostname: "a"})
+	shard.set("10.0.0.1", dnsCacheValue{hostname: "a-updated"})
+
+	v, ok := shard.get("10.0.0.1")
+	if !ok || v.hostname != "a-updated" {
+		t.Fatalf("get() = (%+v, %v), want (hostname=a-updated, true)", v, ok)
+	}
+}
+
+func TestDNSCacheLookupCacheHitHonorsNegativeEntry(t *testing.T) {
+	c := &dnsCache{resolveQueue: make(chan string, 1), lru: newShardedLRU[dnsCacheValue](1, 16)}
+	c.lru.set("203.0.113.1", dnsCacheValue{negative: true, expires: time.Now().Add(time.Minute)})
+
+	if got := c.lookup("203.0.113.1"); got != "203.0.113.1" {
+		t.Fatalf("lookup() = %q, want the raw IP for a cached negative entry", got)
+	}
+	select {
+	case ip := <-c.resolveQueue:
+		t.Fatalf("lookup() enqueued a resolve for a cache hit: %q", ip)
+	default:
+	}
+}
+
+func TestDNSCacheLookupMissEnqueuesResolve(t *testing.T) {
+	c := &dnsCache{resolveQueue: make(chan string, 1), lru: newShardedLRU[dnsCacheValue](1, 16)}
+
+	if got := c.lookup("203.0.113.2"); got != "203.0.113.2" {
+		t.Fatalf("lookup() = %q, want the raw IP on a cache miss", got)
+	}
+	select {
+	case ip := <-c.resolveQueue:
+		if ip != "203.0.113.2" {
+			t.Fatalf("enqueued %q, want 203.0.113.2", ip)
+		}
+	default:
+		t.Fatal("lookup() on a cache miss did not enqueue a resolve")
+	}
+}
+
+func TestDNSCacheEnqueueResolveDedupesInFlight(t *testing.T) {
+	c := &dnsCache{resolveQueue: make(chan string, 1)}
+	c.enqueueResolve("203.0.113.3")
+	c.enqueueResolve("203.0.113.3") // already pending, should not block/queue twice
+
+	if len(c.resolveQueue) != 1 {
+		t.Fatalf("resolveQueue has %d entries, want 1", len(c.resolveQueue))
+	}
+}
+
+func TestFNV32Deterministic(t *testing.T) {
+	if fnv32("203.0.113.1") != fnv32("203.0.113.1") {
+		t.Fatal("fnv32 is not deterministic for the same input")
+	}
+}
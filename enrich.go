@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Enrichment stage: augments each decodedRecord with GeoIP/ASN data for the
+// src/dst IPs and, if a static RIB dump is configured, BGP communities for
+// the destination prefix. It sits between the decoders and the output
+// channel as its own relay goroutine, the same pipe-shaped pattern used by
+// the output sinks.
+
+const geoCacheTTL = 1 * time.Hour
+
+// mmdbReader wraps a MaxMind DB file that can be swapped out at runtime on
+// SIGHUP, so operators can refresh GeoLite2 databases without a restart.
+type mmdbReader struct {
+	path string
+
+	mu sync.RWMutex
+	db *maxminddb.Reader
+}
+
+func newMMDBReader(path string) (*mmdbReader, error) {
+	r := &mmdbReader{path: path}
+	if path == "" {
+		return r, nil
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *mmdbReader) reload() error {
+	if r.path == "" {
+		return nil
+	}
+	db, err := maxminddb.Open(r.path)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	old := r.db
+	r.db = db
+	r.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	log.Printf("Reloaded MMDB %v\n", r.path)
+	return nil
+}
+
+func (r *mmdbReader) lookupCountry(ip net.IP) string {
+	r.mu.RLock()
+	db := r.db
+	r.mu.RUnlock()
+	if db == nil || ip == nil {
+		return ""
+	}
+	var rec struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := db.Lookup(ip, &rec); err != nil {
+		return ""
+	}
+	return rec.Country.ISOCode
+}
+
+func (r *mmdbReader) lookupASN(ip net.IP) uint32 {
+	r.mu.RLock()
+	db := r.db
+	r.mu.RUnlock()
+	if db == nil || ip == nil {
+		return 0
+	}
+	var rec struct {
+		AutonomousSystemNumber uint32 `maxminddb:"autonomous_system_number"`
+	}
+	if err := db.Lookup(ip, &rec); err != nil {
+		return 0
+	}
+	return rec.AutonomousSystemNumber
+}
+
+// geoCacheShards/geoCacheSize bound the GeoIP cache the same way the
+// reverse-DNS cache in dnscache.go is bounded: a fixed-capacity, sharded LRU
+// rather than a map that grows by one entry per distinct IP forever.
+const (
+	geoCacheShards = 16
+	geoCacheSize   = 65536
+)
+
+type geoCacheValue struct {
+	Country string
+	ASN     uint32
+	expires time.Time
+}
+
+// geoCache is the bounded, sharded GeoIP/ASN lookup cache, built on the
+// same shardedLRU used by dnsCache.
+type geoCache struct {
+	lru *shardedLRU[geoCacheValue]
+}
+
+func newGeoCache() *geoCache {
+	return &geoCache{lru: newShardedLRU[geoCacheValue](geoCacheShards, geoCacheSize)}
+}
+
+func (c *geoCache) lookup(countryDB, asnDB *mmdbReader, ipAddr string) (string, uint32) {
+	if value, ok := c.lru.get(ipAddr); ok && time.Now().Before(value.expires) {
+		return value.Country, value.ASN
+	}
+
+	ip := net.ParseIP(ipAddr)
+	country := countryDB.lookupCountry(ip)
+	asn := asnDB.lookupASN(ip)
+
+	c.lru.set(ipAddr, geoCacheValue{Country: country, ASN: asn, expires: time.Now().Add(geoCacheTTL)})
+
+	return country, asn
+}
+
+// bgpTrieNode is one node of a binary trie keyed by prefix bits. Walking it
+// bit-by-bit from the root and remembering the deepest hasRoute node visited
+// gives the longest matching prefix in O(address length) instead of an O(n)
+// scan over every loaded route.
+type bgpTrieNode struct {
+	children    [2]*bgpTrieNode
+	communities string
+	hasRoute    bool
+}
+
+// bgpRIB is built once, up front, by loadStaticRIB, before any concurrent
+// lookups begin, so (unlike dnsCache/geoCache) it needs no locking of its
+// own.
+type bgpRIB struct {
+	root *bgpTrieNode
+}
+
+func bitsOf(ip net.IP) []byte {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip.To16()
+}
+
+// insert adds prefix's bits to the trie, marking the final node as a route
+// carrying communities.
+func (b *bgpRIB) insert(prefix *net.IPNet, communities string) {
+	if b.root == nil {
+		b.root = &bgpTrieNode{}
+	}
+	ones, _ := prefix.Mask.Size()
+	addr := bitsOf(prefix.IP)
+
+	node := b.root
+	for i := 0; i < ones; i++ {
+		bit := (addr[i/8] >> (7 - uint(i%8))) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &bgpTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.communities = communities
+	node.hasRoute = true
+}
+
+// bgpCommunityDelim joins communities in the string we attach to a record,
+// rather than the raw space-separated RIB dump format: a record's
+// DstBGPCommunities is spliced unescaped into InfluxDB line protocol, where
+// an unescaped space is the tag/field separator.
+const bgpCommunityDelim = ";"
+
+// loadStaticRIB reads a RIB dump where each line is "prefix communities",
+// e.g. "203.0.113.0/24 65000:100 65000:200", into a longest-prefix-match
+// trie. This is the offline alternative to learning communities from a
+// live BGP peer.
+func loadStaticRIB(path string) (*bgpRIB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rib := &bgpRIB{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		_, prefix, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			log.Printf("Skipping invalid RIB line %q: %v\n", line, err)
+			continue
+		}
+		communities := ""
+		if len(fields) == 2 {
+			communities = strings.Join(strings.Fields(fields[1]), bgpCommunityDelim)
+		}
+		rib.insert(prefix, communities)
+	}
+	return rib, scanner.Err()
+}
+
+// lookup returns the communities of the longest matching prefix for ip, or
+// "" if the RIB has no covering route.
+func (b *bgpRIB) lookup(ip net.IP) string {
+	if ip == nil || b.root == nil {
+		return ""
+	}
+	addr := bitsOf(ip)
+
+	node := b.root
+	best := ""
+	if node.hasRoute {
+		best = node.communities
+	}
+	for i := 0; i < len(addr)*8; i++ {
+		bit := (addr[i/8] >> (7 - uint(i%8))) & 1
+		node = node.children[bit]
+		if node == nil {
+			break
+		}
+		if node.hasRoute {
+			best = node.communities
+		}
+	}
+	return best
+}
+
+// enricher is the full GeoIP/ASN/BGP stage applied to every record before
+// it reaches the output channel.
+type enricher struct {
+	countryDB  *mmdbReader
+	asnDB      *mmdbReader
+	geoEnabled bool
+	geo        *geoCache
+	rib        *bgpRIB
+}
+
+func newEnricher(geoCountryPath, geoASNPath, bgpRIBPath string) (*enricher, error) {
+	countryDB, err := newMMDBReader(geoCountryPath)
+	if err != nil {
+		return nil, err
+	}
+	asnDB, err := newMMDBReader(geoASNPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var rib *bgpRIB
+	if bgpRIBPath != "" {
+		rib, err = loadStaticRIB(bgpRIBPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e := &enricher{
+		countryDB:  countryDB,
+		asnDB:      asnDB,
+		geoEnabled: geoCountryPath != "" || geoASNPath != "",
+		geo:        newGeoCache(),
+		rib:        rib,
+	}
+	e.watchSIGHUP()
+	return e, nil
+}
+
+func (e *enricher) watchSIGHUP() {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := e.countryDB.reload(); err != nil {
+				log.Printf("GeoIP country DB reload failed: %v\n", err)
+			}
+			if err := e.asnDB.reload(); err != nil {
+				log.Printf("GeoIP ASN DB reload failed: %v\n", err)
+			}
+		}
+	}()
+}
+
+func (e *enricher) enrich(record decodedRecord) decodedRecord {
+	if e.geoEnabled {
+		if record.Ipv4SrcAddr != "" {
+			record.SrcCountry, record.SrcASN = e.geo.lookup(e.countryDB, e.asnDB, record.Ipv4SrcAddr)
+		}
+		if record.Ipv4DstAddr != "" {
+			record.DstCountry, record.DstASN = e.geo.lookup(e.countryDB, e.asnDB, record.Ipv4DstAddr)
+		}
+	}
+	if e.rib != nil && record.Ipv4DstAddr != "" {
+		record.DstBGPCommunities = e.rib.lookup(net.ParseIP(record.Ipv4DstAddr))
+	}
+	return record
+}
+
+// runEnrichmentRelay reads decoded records off decodedChannel, enriches
+// each one, and forwards it to outputChannel.
+func runEnrichmentRelay(decodedChannel, outputChannel chan decodedRecord, e *enricher) {
+	for record := range decodedChannel {
+		outputChannel <- e.enrich(record)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRIBFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rib.txt")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestLoadStaticRIBLongestPrefixMatch(t *testing.T) {
+	path := writeRIBFile(t, ""+
+		"# comment line, and a blank line follow\n"+
+		"\n"+
+		"203.0.113.0/24 65000:100 65000:200\n"+
+		"203.0.113.128/25 65000:300\n"+
+		"198.51.100.0/24\n")
+
+	rib, err := loadStaticRIB(path)
+	if err != nil {
+		t.Fatalf("loadStaticRIB: %v", err)
+	}
+
+	// Falls in both routes; the /25 is the longer (more specific) match.
+	if got := rib.lookup(net.ParseIP("203.0.113.200")); got != "65000:300" {
+		t.Fatalf("lookup(203.0.113.200) = %q, want 65000:300", got)
+	}
+	// Only matches the /24.
+	if got := rib.lookup(net.ParseIP("203.0.113.10")); got != "65000:100;65000:200" {
+		t.Fatalf("lookup(203.0.113.10) = %q, want 65000:100;65000:200", got)
+	}
+	// Route with no communities listed.
+	if got := rib.lookup(net.ParseIP("198.51.100.1")); got != "" {
+		t.Fatalf("lookup(198.51.100.1) = %q, want empty string", got)
+	}
+	// No covering route at all.
+	if got := rib.lookup(net.ParseIP("192.0.2.1")); got != "" {
+		t.Fatalf("lookup(192.0.2.1) = %q, want empty string", got)
+	}
+}
+
+func TestLoadStaticRIBSkipsInvalidLines(t *testing.T) {
+	path := writeRIBFile(t, "not-a-prefix 65000:100\n203.0.113.0/24 65000:100\n")
+
+	rib, err := loadStaticRIB(path)
+	if err != nil {
+		t.Fatalf("loadStaticRIB: %v", err)
+	}
+	// The invalid line should be skipped, leaving only the valid /24 route.
+	if got := rib.lookup(net.ParseIP("203.0.113.10")); got != "65000:100" {
+		t.Fatalf("lookup(203.0.113.10) = %q, want 65000:100", got)
+	}
+	if got := rib.lookup(net.ParseIP("192.0.2.1")); got != "" {
+		t.Fatalf("lookup(192.0.2.1) = %q, want empty string (no covering route)", got)
+	}
+}
+
+func TestBGPRIBLookupNilIP(t *testing.T) {
+	rib := &bgpRIB{}
+	if got := rib.lookup(nil); got != "" {
+		t.Fatalf("lookup(nil) = %q, want empty string", got)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Generic bounded, sharded LRU used by both the reverse-DNS cache
+// (dnscache.go) and the GeoIP cache (enrich.go), so each cache need only
+// supply its value type and doesn't reimplement shard hashing/eviction.
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+// lruShard is one LRU stripe of a shardedLRU, so lookups for different
+// keys rarely contend on the same mutex.
+type lruShard[V any] struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func newLRUShard[V any](capacity int) *lruShard[V] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &lruShard[V]{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (s *lruShard[V]) get(key string) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	s.ll.MoveToFront(el)
+	return el.Value.(*lruEntry[V]).value, true
+}
+
+func (s *lruShard[V]) set(key string, value V) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		el.Value.(*lruEntry[V]).value = value
+		s.ll.MoveToFront(el)
+		return
+	}
+
+	el := s.ll.PushFront(&lruEntry[V]{key: key, value: value})
+	s.items[key] = el
+	if s.ll.Len() > s.capacity {
+		oldest := s.ll.Back()
+		if oldest != nil {
+			s.ll.Remove(oldest)
+			delete(s.items, oldest.Value.(*lruEntry[V]).key)
+		}
+	}
+}
+
+// shardedLRU picks a shard per key by FNV-1a hash, so concurrent lookups
+// for different keys rarely contend on the same shard's mutex.
+type shardedLRU[V any] struct {
+	shards []*lruShard[V]
+}
+
+func newShardedLRU[V any](numShards, totalCapacity int) *shardedLRU[V] {
+	capacityPerShard := totalCapacity / numShards
+	c := &shardedLRU[V]{}
+	for i := 0; i < numShards; i++ {
+		c.shards = append(c.shards, newLRUShard[V](capacityPerShard))
+	}
+	return c
+}
+
+func (c *shardedLRU[V]) shardFor(key string) *lruShard[V] {
+	return c.shards[fnv32(key)%uint32(len(c.shards))]
+}
+
+func (c *shardedLRU[V]) get(key string) (V, bool) {
+	return c.shardFor(key).get(key)
+}
+
+func (c *shardedLRU[V]) set(key string, value V) {
+	c.shardFor(key).set(key, value)
+}
@@ -5,11 +5,9 @@ import (
 	"encoding/binary"
 	"github.com/namsral/flag"
 	"fmt"
-	"encoding/json"
 	"log"
 	"net"
 	"time"
-	"sync"
 )
 
 // NetFlow v5 implementation
@@ -61,6 +59,25 @@ type decodedRecord struct {
 	SrcHostName       string
 	DstHostName       string
 	Duration          uint16
+
+	// Fields holds values that have no dedicated struct field above:
+	// template-driven elements decoded from NetFlow v9/IPFIX records, and
+	// sFlow counter values. Unset for v5.
+	Fields map[string]interface{} `json:",omitempty"`
+
+	// Agent, SampleType and SamplingRate are populated by the sFlow decoder;
+	// they are left empty/zero for NetFlow records and omitted from tags.
+	Agent        string `json:",omitempty"`
+	SampleType   string `json:",omitempty"`
+	SamplingRate uint32 `json:",omitempty"`
+
+	// SrcCountry/DstCountry/SrcASN/DstASN/DstBGPCommunities are populated
+	// by the enrichment stage; left empty/zero when enrichment is disabled.
+	SrcCountry        string `json:",omitempty"`
+	DstCountry        string `json:",omitempty"`
+	SrcASN            uint32 `json:",omitempty"`
+	DstASN            uint32 `json:",omitempty"`
+	DstBGPCommunities string `json:",omitempty"`
 }
 
 func intToIPv4Addr(intAddr uint32) net.IP {
@@ -98,87 +115,76 @@ func decodeRecord(header *header, binRecord *binaryRecord, remoteAddr *net.UDPAd
 	return decodedRecord
 }
 
-func pipeOutputToStdout(outputChannel chan decodedRecord) {
-	var record decodedRecord
-	for {
-		record = <-outputChannel
-		out, _ := json.Marshal(record)
-		fmt.Println(string(out))
+func formatLineProtocol(record decodedRecord) []byte {
+	tags := fmt.Sprintf("host=%s,srcAddr=%s,dstAddr=%s,srcHostName=%s,dstHostName=%s,protocol=%d,srcPort=%d,dstPort=%d,input=%d,output=%d",
+		record.Host, record.Ipv4SrcAddr, record.Ipv4DstAddr, record.SrcHostName, record.DstHostName, record.Protocol, record.L4SrcPort, record.L4DstPort, record.InputSnmp, record.OutputSnmp)
+	if record.Agent != "" {
+		tags += fmt.Sprintf(",agent=%s", record.Agent)
 	}
-}
-
-type cacheRecord struct {
-	Hostname string
-	timeout time.Time
-}
-
-var (
-	cache = map[string]cacheRecord{}
-	cacheMutex = sync.RWMutex{}
-)
-
-func lookUpWithCache (ipAddr string) string {
-	hostname :=ipAddr
-	cacheMutex.Lock()
-	hostnameFromCache :=cache[ipAddr]
-	cacheMutex.Unlock()
-	if (hostnameFromCache == cacheRecord{} || time.Now().After(hostnameFromCache.timeout)) {
-		hostTemp, err := net.LookupAddr(ipAddr)
-		if err == nil {
-			hostname = hostTemp[0]
-		}
-		cacheMutex.Lock()
-		cache[ipAddr] = cacheRecord{hostname,time.Now().AddDate(0,0,1)}
-		cacheMutex.Unlock()
-	} else {
-		hostname = hostnameFromCache.Hostname
+	if record.SampleType != "" {
+		tags += fmt.Sprintf(",sampleType=%s", record.SampleType)
 	}
-	return hostname
-}
-
-func formatLineProtocol(record decodedRecord) []byte {
-	return []byte(fmt.Sprintf("netflow,host=%s,srcAddr=%s,dstAddr=%s,srcHostName=%s,dstHostName=%s,protocol=%d,srcPort=%d,dstPort=%d,input=%d,output=%d inBytes=%d,inPackets=%d,duration=%d %d",
-		record.Host,record.Ipv4SrcAddr,record.Ipv4DstAddr,record.SrcHostName,record.DstHostName,record.Protocol,record.L4SrcPort,record.L4DstPort,record.InputSnmp,record.OutputSnmp,
-		record.InBytes,record.InPkts,record.Duration,
+	if record.SamplingRate > 0 {
+		tags += fmt.Sprintf(",samplingRate=%d", record.SamplingRate)
+	}
+	if record.SrcCountry != "" {
+		tags += fmt.Sprintf(",srcCountry=%s", record.SrcCountry)
+	}
+	if record.DstCountry != "" {
+		tags += fmt.Sprintf(",dstCountry=%s", record.DstCountry)
+	}
+	if record.SrcASN != 0 {
+		tags += fmt.Sprintf(",srcASN=%d", record.SrcASN)
+	}
+	if record.DstASN != 0 {
+		tags += fmt.Sprintf(",dstASN=%d", record.DstASN)
+	}
+	if record.DstBGPCommunities != "" {
+		tags += fmt.Sprintf(",dstBGPCommunities=%s", record.DstBGPCommunities)
+	}
+	return []byte(fmt.Sprintf("netflow,%s inBytes=%d,inPackets=%d,duration=%d %d",
+		tags,
+		record.InBytes, record.InPkts, record.Duration,
 		uint64((uint64(record.UnixSec)*uint64(1000000000))+uint64(record.UnixNsec))))
 }
 
-func pipeOutputToUDPSocket(outputChannel chan decodedRecord, targetAddr string) {
-	/* Setting-up the socket to send data */
+// protocol selects how incoming packets on -in are interpreted; set from
+// the -protocol flag in main. "auto" detects sFlow by its version word and
+// falls back to NetFlow v5/v9/IPFIX otherwise.
+var protocol = "auto"
 
-	remote, err := net.ResolveUDPAddr("udp", targetAddr)
-	if err != nil {
-		log.Printf("Name resolution failed: %v\n", err)
-	} else {
+func handlePacket(raw []byte, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	if len(raw) < 2 {
+		log.Printf("Error: packet from %v too short to contain a version\n", remoteAddr)
+		return
+	}
 
-		for {
-			connection, err := net.DialUDP("udp", nil, remote)
-			defer connection.Close()
-			if err != nil {
-				log.Printf("Connection failed: %v\n", err)
-			} else {
-				var record decodedRecord
-				for {
-					record = <-outputChannel
-					var buf = formatLineProtocol(record)
-					conn := connection
-					conn.SetDeadline(time.Now().Add(3 * time.Second))
-					_, err := conn.Write(buf)
-					if err != nil {
-						log.Printf("Send Error: %v\n", err)
-						break
-					}
-				}
-			}
-		}
+	if protocol != "netflow" && looksLikeSFlow(raw) {
+		handlePacketSFlow(raw, remoteAddr, outputChannel)
+		return
+	}
+	if protocol == "sflow" {
+		log.Printf("Error: packet from %v does not look like sFlow, dropping\n", remoteAddr)
+		return
+	}
+
+	switch binary.BigEndian.Uint16(raw[0:2]) {
+	case 9:
+		handlePacketV9(raw, remoteAddr, outputChannel)
+	case 10:
+		handlePacketIPFIX(raw, remoteAddr, outputChannel)
+	default:
+		handlePacketV5(raw, remoteAddr, outputChannel)
 	}
 }
 
-func handlePacket(buf *bytes.Buffer, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+func handlePacketV5(raw []byte, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	buf := bytes.NewBuffer(raw)
 	header := header{}
 	err := binary.Read(buf, binary.BigEndian, &header)
 	if err != nil {
 		log.Printf("Error: %v\n", err)
+		decodeErrorsTotal.WithLabelValues("v5_header").Inc()
 	} else {
 
 		for i := 0; i < int(header.FlowRecords); i++ {
@@ -186,10 +192,12 @@ func handlePacket(buf *bytes.Buffer, remoteAddr *net.UDPAddr, outputChannel chan
 			err := binary.Read(buf, binary.BigEndian, &record)
 			if err != nil {
 				log.Printf("binary.Read failed: %v\n", err)
+				decodeErrorsTotal.WithLabelValues("v5_record").Inc()
 				break
 			}
 
 			decodedRecord := decodeRecord(&header, &record, remoteAddr)
+			flowRecordsDecodedTotal.WithLabelValues("netflow5").Inc()
 			outputChannel <- decodedRecord
 		}
 	}
@@ -202,24 +210,88 @@ func main() {
 		outMethod              string
 		outDestination         string
 		receiveBufferSizeBytes int
+		batchSize              int
+		workerCount            int
+		kafkaBrokers           string
+		kafkaTopic             string
+		influxURL              string
+		influxOrg              string
+		influxBucket           string
+		influxToken            string
+		filePath               string
+		fileMaxSizeMB          int
+		fileMaxAgeDays         int
+		geoCountryDBPath       string
+		geoASNDBPath           string
+		bgpRIBPath             string
+		dnsWorkers             int
+		dnsCacheSize           int
+		dnsPositiveTTL         time.Duration
+		dnsNegativeTTL         time.Duration
+		metricsAddr            string
 	)
 	flag.StringVar(&inSource, "in", "0.0.0.0:2055", "Address and port to listen NetFlow packets")
-	flag.StringVar(&outMethod, "method", "stdout", "Output method: stdout, udp")
-	flag.StringVar(&outDestination, "out", "", "Address and port of influxdb to send decoded data")
+	flag.StringVar(&outMethod, "method", "stdout", "Comma-separated output methods: stdout, udp, kafka, influxv2, file")
+	flag.StringVar(&outDestination, "out", "", "Address and port of influxdb to send decoded data (method udp)")
 	flag.IntVar(&receiveBufferSizeBytes, "buffer", 212992, "Size of RxQueue, i.e. value for SO_RCVBUF in bytes")
+	flag.StringVar(&protocol, "protocol", "auto", "Protocol to expect on -in: netflow, sflow, or auto")
+	flag.IntVar(&batchSize, "batch-size", 32, "Number of packets to pull per recvmmsg batch")
+	flag.IntVar(&workerCount, "workers", 4, "Number of worker goroutines decoding packets")
+	flag.StringVar(&kafkaBrokers, "kafka-brokers", "", "Comma-separated Kafka broker addresses (method kafka)")
+	flag.StringVar(&kafkaTopic, "kafka-topic", "gonflux", "Kafka topic to publish decoded records to (method kafka)")
+	flag.StringVar(&influxURL, "influx-url", "", "InfluxDB v2 base URL, e.g. https://influx.example.com (method influxv2)")
+	flag.StringVar(&influxOrg, "influx-org", "", "InfluxDB v2 organization (method influxv2)")
+	flag.StringVar(&influxBucket, "influx-bucket", "", "InfluxDB v2 bucket (method influxv2)")
+	flag.StringVar(&influxToken, "influx-token", "", "InfluxDB v2 API token (method influxv2)")
+	flag.StringVar(&filePath, "file-path", "gonflux.ndjson", "Path to the rotating NDJSON archive (method file)")
+	flag.IntVar(&fileMaxSizeMB, "file-max-size-mb", 100, "Max size in MB before the archive file is rotated (method file)")
+	flag.IntVar(&fileMaxAgeDays, "file-max-age-days", 7, "Max age in days before a rotated archive file is deleted (method file)")
+	flag.StringVar(&geoCountryDBPath, "geoip-country-db", "", "Path to a GeoLite2 Country/City MMDB for src/dst country enrichment")
+	flag.StringVar(&geoASNDBPath, "geoip-asn-db", "", "Path to a GeoLite2 ASN MMDB for src/dst ASN enrichment")
+	flag.StringVar(&bgpRIBPath, "bgp-rib-file", "", "Path to a static RIB dump (\"prefix communities\" per line) for destination BGP community enrichment")
+	flag.IntVar(&dnsWorkers, "dns-workers", 4, "Number of background goroutines resolving reverse-DNS lookups")
+	flag.IntVar(&dnsCacheSize, "dns-cache-size", 65536, "Total entries held across all reverse-DNS cache shards")
+	flag.DurationVar(&dnsPositiveTTL, "dns-positive-ttl", 24*time.Hour, "How long a resolved hostname is cached")
+	flag.DurationVar(&dnsNegativeTTL, "dns-negative-ttl", 5*time.Minute, "How long a failed/empty PTR lookup is cached")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics and pprof on, e.g. :9090 (disabled if empty)")
 	flag.Parse()
 
-	/* Create output pipe */
-	outputChannel := make(chan decodedRecord, 100)
-	switch outMethod {
-	case "stdout":
-		go pipeOutputToStdout(outputChannel)
-	case "udp":
-		go pipeOutputToUDPSocket(outputChannel, outDestination)
+	globalDNSCache = newDNSCache(dnsCacheSize, dnsWorkers, dnsPositiveTTL, dnsNegativeTTL)
+
+	switch protocol {
+	case "netflow", "sflow", "auto":
 	default:
-		log.Fatalf("Unknown schema: %v\n", outMethod)
+		log.Fatalf("Unknown protocol: %v\n", protocol)
+	}
 
+	/* Create the enrichment relay between the decoders and the output pipe */
+	decodedChannel := make(chan decodedRecord, 100)
+	outputChannel := make(chan decodedRecord, 100)
+	enricher, err := newEnricher(geoCountryDBPath, geoASNDBPath, bgpRIBPath)
+	if err != nil {
+		log.Fatalf("Enrichment setup failed: %v\n", err)
 	}
+	go runEnrichmentRelay(decodedChannel, outputChannel, enricher)
+	go sweepExpiredTemplates()
+	registerOutputChannelDepthMetric(outputChannel)
+	registerTemplateCacheSizeMetric()
+	startMetricsServer(metricsAddr)
+
+	fanout := buildOutputs(outputConfig{
+		methods:        outMethod,
+		udpDestination: outDestination,
+		kafkaBrokers:   kafkaBrokers,
+		kafkaTopic:     kafkaTopic,
+		influxURL:      influxURL,
+		influxOrg:      influxOrg,
+		influxBucket:   influxBucket,
+		influxToken:    influxToken,
+		filePath:       filePath,
+		fileMaxSizeMB:  fileMaxSizeMB,
+		fileMaxAgeDays: fileMaxAgeDays,
+	})
+	go runOutputLoop(outputChannel, fanout)
+	watchShutdownSignals(fanout)
 
 	/* Start listening on the specified port */
 	log.Printf("Start listening on %v and sending to %v %v\n", inSource, outMethod, outDestination)
@@ -238,21 +310,7 @@ func main() {
 			if err != nil {
 				log.Println(err)
 			} else {
-
-				/* Infinite-loop for reading packets */
-				for {
-					buf := make([]byte, 4096)
-					rlen, remote, err := conn.ReadFromUDP(buf)
-
-					if err != nil {
-						log.Printf("Error: %v\n", err)
-					} else {
-
-						stream := bytes.NewBuffer(buf[:rlen])
-
-						go handlePacket(stream, remote, outputChannel)
-					}
-				}
+				runBatchedReceiver(conn, batchSize, workerCount, decodedChannel)
 			}
 		}
 		defer conn.Close()
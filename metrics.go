@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics and pprof, served together on -metrics-addr so
+// operators can see where packets/records are going (or silently piling
+// up) without instrumenting the process externally.
+
+var (
+	packetsReceivedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gonflux_packets_received_total",
+		Help: "UDP packets received, labeled by exporter source IP.",
+	}, []string{"exporter"})
+
+	flowRecordsDecodedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gonflux_flow_records_decoded_total",
+		Help: "Flow records successfully decoded, labeled by protocol.",
+	}, []string{"protocol"})
+
+	decodeErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gonflux_decode_errors_total",
+		Help: "Decode failures, labeled by error type.",
+	}, []string{"type"})
+
+	outputSendErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gonflux_output_send_errors_total",
+		Help: "Errors writing a record to an output sink, labeled by sink.",
+	}, []string{"sink"})
+
+	dnsCacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gonflux_dns_cache_hits_total",
+		Help: "Reverse-DNS cache lookups served from cache.",
+	})
+	dnsCacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gonflux_dns_cache_misses_total",
+		Help: "Reverse-DNS cache lookups that missed or found an expired entry.",
+	})
+
+	droppedPacketsRxqOvfl = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gonflux_dropped_packets_rxq_ovfl",
+		Help: "Cumulative packets dropped by the kernel due to receive queue overflow (SO_RXQ_OVFL).",
+	})
+)
+
+// registerOutputChannelDepthMetric exposes how full the output channel is,
+// since it silently backpressures the UDP receiver once it fills.
+func registerOutputChannelDepthMetric(outputChannel chan decodedRecord) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gonflux_output_channel_depth",
+		Help: "Number of decoded records currently buffered in the output channel.",
+	}, func() float64 { return float64(len(outputChannel)) })
+}
+
+// registerTemplateCacheSizeMetric exposes the live NetFlow v9/IPFIX
+// template cache size.
+func registerTemplateCacheSizeMetric() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gonflux_template_cache_size",
+		Help: "Number of cached NetFlow v9/IPFIX templates across all exporters.",
+	}, func() float64 {
+		templateMutex.RLock()
+		defer templateMutex.RUnlock()
+		return float64(len(templateCache))
+	})
+}
+
+func startMetricsServer(addr string) {
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		log.Printf("Metrics and pprof listening on %v\n", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server error: %v\n", err)
+		}
+	}()
+}
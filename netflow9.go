@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NetFlow v9 (RFC 3954) and IPFIX (RFC 7011) template-based decoding.
+//
+// Both protocols are sets of FlowSets: a stream of (ID, Length) blocks that
+// are either template definitions or data records matching a previously
+// seen template. We keep a per-exporter template cache keyed by source IP,
+// source/observation domain ID and template ID, and use it to turn the
+// opaque data records into decodedRecord values.
+
+const (
+	v9TemplateSetID        = 0
+	v9OptionsTemplateSetID = 1
+	ipfixTemplateSetID     = 2
+	ipfixOptionsSetID      = 3
+
+	templateTTL        = 30 * time.Minute
+	pendingRecordTTL   = 2 * time.Second
+	maxPendingFlowSets = 256
+)
+
+// Element IDs shared by NetFlow v9 and IPFIX that map onto the existing
+// decodedRecord/binaryRecord fields. Anything else is decoded into Fields.
+const (
+	ieOctetDeltaCount  = 1
+	iePacketDeltaCount = 2
+	ieProtocol         = 4
+	ieL4SrcPort        = 7
+	ieIPv4SrcAddr      = 8
+	ieInputSnmp        = 10
+	ieL4DstPort        = 11
+	ieIPv4DstAddr      = 12
+	ieOutputSnmp       = 14
+	ieIPv4NextHop      = 15
+	ieLastSwitched     = 21
+	ieFirstSwitched    = 22
+)
+
+type templateKey struct {
+	SourceIP   string
+	SourceID   uint32
+	TemplateID uint16
+}
+
+type templateField struct {
+	Type   uint16
+	Length uint16
+}
+
+type flowTemplate struct {
+	Fields    []templateField
+	ExpiresAt time.Time
+}
+
+type pendingFlowSet struct {
+	Data      []byte
+	ExpiresAt time.Time
+}
+
+var (
+	templateCache = map[templateKey]flowTemplate{}
+	templateMutex sync.RWMutex
+
+	pendingFlowSets      = map[templateKey][]pendingFlowSet{}
+	pendingFlowSetsMutex sync.Mutex
+
+	droppedRecordsNoTemplate uint64
+	droppedRecordsMutex      sync.Mutex
+)
+
+type flowSetHeader struct {
+	ID     uint16
+	Length uint16
+}
+
+func incrDroppedNoTemplate() {
+	droppedRecordsMutex.Lock()
+	droppedRecordsNoTemplate++
+	droppedRecordsMutex.Unlock()
+	decodeErrorsTotal.WithLabelValues("no_template").Inc()
+}
+
+// sweepExpiredTemplates runs for the lifetime of the process, periodically
+// deleting templates and pending flowsets whose TTL has passed so a churny
+// population of exporters (or a spoofed source IP) can't grow the caches
+// without bound; lookupTemplate only filters expired entries at read time
+// and never removes them on its own.
+func sweepExpiredTemplates() {
+	ticker := time.NewTicker(templateTTL)
+	for range ticker.C {
+		now := time.Now()
+
+		templateMutex.Lock()
+		for key, tmpl := range templateCache {
+			if now.After(tmpl.ExpiresAt) {
+				delete(templateCache, key)
+			}
+		}
+		templateMutex.Unlock()
+
+		pendingFlowSetsMutex.Lock()
+		for key, pending := range pendingFlowSets {
+			live := pending[:0]
+			for _, p := range pending {
+				if now.After(p.ExpiresAt) {
+					incrDroppedNoTemplate()
+					continue
+				}
+				live = append(live, p)
+			}
+			if len(live) == 0 {
+				delete(pendingFlowSets, key)
+			} else {
+				pendingFlowSets[key] = live
+			}
+		}
+		pendingFlowSetsMutex.Unlock()
+	}
+}
+
+func storeTemplate(key templateKey, tmpl flowTemplate, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	templateMutex.Lock()
+	templateCache[key] = tmpl
+	templateMutex.Unlock()
+
+	pendingFlowSetsMutex.Lock()
+	pending := pendingFlowSets[key]
+	delete(pendingFlowSets, key)
+	pendingFlowSetsMutex.Unlock()
+
+	for _, p := range pending {
+		if time.Now().After(p.ExpiresAt) {
+			incrDroppedNoTemplate()
+			continue
+		}
+		decodeDataSet(tmpl, p.Data, remoteAddr, outputChannel)
+	}
+}
+
+func lookupTemplate(key templateKey) (flowTemplate, bool) {
+	templateMutex.RLock()
+	defer templateMutex.RUnlock()
+	tmpl, ok := templateCache[key]
+	if !ok || time.Now().After(tmpl.ExpiresAt) {
+		return flowTemplate{}, false
+	}
+	return tmpl, true
+}
+
+func bufferPendingFlowSet(key templateKey, data []byte) {
+	buf := make([]byte, len(data))
+	copy(buf, data)
+
+	pendingFlowSetsMutex.Lock()
+	defer pendingFlowSetsMutex.Unlock()
+	if len(pendingFlowSets[key]) >= maxPendingFlowSets {
+		incrDroppedNoTemplate()
+		return
+	}
+	pendingFlowSets[key] = append(pendingFlowSets[key], pendingFlowSet{
+		Data:      buf,
+		ExpiresAt: time.Now().Add(pendingRecordTTL),
+	})
+}
+
+// decodeDataSet walks a Data FlowSet/Set one record at a time rather than
+// striding by a fixed RecordLen, since an IPFIX template with a
+// variable-length IE (ieVariableLength) makes the record length impossible
+// to know up front - it's carried inline, per record, in the data itself.
+func decodeDataSet(tmpl flowTemplate, data []byte, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	for offset := 0; offset < len(data); {
+		record, consumed, ok := decodeTemplateRecord(tmpl, data[offset:], remoteAddr)
+		if !ok {
+			decodeErrorsTotal.WithLabelValues("short_data_record").Inc()
+			return
+		}
+		offset += consumed
+		flowRecordsDecodedTotal.WithLabelValues("netflow9_ipfix").Inc()
+		outputChannel <- record
+	}
+}
+
+// ieVariableLength is the RFC 7011 7.1 sentinel template field length
+// meaning "the actual length is carried inline before each value", not a
+// literal 65535-byte field.
+const ieVariableLength = 0xFFFF
+
+func decodeTemplateRecord(tmpl flowTemplate, data []byte, remoteAddr *net.UDPAddr) (decodedRecord, int, bool) {
+	record := decodedRecord{
+		Host:   remoteAddr.IP.String(),
+		Fields: map[string]interface{}{},
+	}
+
+	offset := 0
+	for _, field := range tmpl.Fields {
+		length := int(field.Length)
+		if field.Length == ieVariableLength {
+			var ok bool
+			length, offset, ok = readVariableLength(data, offset)
+			if !ok {
+				return decodedRecord{}, 0, false
+			}
+		}
+		if offset+length > len(data) {
+			return decodedRecord{}, 0, false
+		}
+		raw := data[offset : offset+length]
+		offset += length
+
+		switch field.Type {
+		case ieIPv4SrcAddr:
+			record.Ipv4SrcAddr = net.IP(raw).String()
+			record.SrcHostName = lookUpWithCache(record.Ipv4SrcAddr)
+		case ieIPv4DstAddr:
+			record.Ipv4DstAddr = net.IP(raw).String()
+			record.DstHostName = lookUpWithCache(record.Ipv4DstAddr)
+		case ieIPv4NextHop:
+			record.Ipv4NextHop = net.IP(raw).String()
+		case ieL4SrcPort:
+			record.L4SrcPort = uint16(decodeUint(raw))
+		case ieL4DstPort:
+			record.L4DstPort = uint16(decodeUint(raw))
+		case ieProtocol:
+			record.Protocol = uint8(decodeUint(raw))
+		case ieInputSnmp:
+			record.InputSnmp = uint16(decodeUint(raw))
+		case ieOutputSnmp:
+			record.OutputSnmp = uint16(decodeUint(raw))
+		case ieOctetDeltaCount:
+			record.InBytes = uint32(decodeUint(raw))
+		case iePacketDeltaCount:
+			record.InPkts = uint32(decodeUint(raw))
+		case ieFirstSwitched:
+			record.FirstInt = uint32(decodeUint(raw))
+		case ieLastSwitched:
+			record.LastInt = uint32(decodeUint(raw))
+		default:
+			record.Fields[fieldName(field.Type)] = decodeUint(raw)
+		}
+	}
+
+	if record.LastInt >= record.FirstInt {
+		record.Duration = uint16((record.LastInt - record.FirstInt) / 1000)
+	}
+
+	return record, offset, true
+}
+
+// readVariableLength decodes an RFC 7011 7.1 variable-length field prefix
+// starting at offset: a single length octet, or 0xFF followed by a 2-octet
+// length for values 255 bytes or longer. It returns the decoded length and
+// the offset of the value that follows.
+func readVariableLength(data []byte, offset int) (length int, next int, ok bool) {
+	if offset >= len(data) {
+		return 0, 0, false
+	}
+	length = int(data[offset])
+	offset++
+	if length == 0xFF {
+		if offset+2 > len(data) {
+			return 0, 0, false
+		}
+		length = int(binary.BigEndian.Uint16(data[offset : offset+2]))
+		offset += 2
+	}
+	return length, offset, true
+}
+
+func fieldName(ieType uint16) string {
+	return "ie" + strconv.Itoa(int(ieType))
+}
+
+func decodeUint(raw []byte) uint64 {
+	var v uint64
+	for _, b := range raw {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// parseTemplateSet reads one or more template definitions out of a Template
+// FlowSet/Set body (v9 ID 0, IPFIX ID 2) and caches them.
+func parseTemplateSet(body []byte, sourceIP string, sourceID uint32, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	for len(body) >= 4 {
+		templateID := binary.BigEndian.Uint16(body[0:2])
+		fieldCount := binary.BigEndian.Uint16(body[2:4])
+		body = body[4:]
+
+		fields := make([]templateField, 0, fieldCount)
+		for i := 0; i < int(fieldCount); i++ {
+			if len(body) < 4 {
+				return
+			}
+			f := templateField{
+				Type:   binary.BigEndian.Uint16(body[0:2]),
+				Length: binary.BigEndian.Uint16(body[2:4]),
+			}
+			body = body[4:]
+			fields = append(fields, f)
+		}
+
+		key := templateKey{SourceIP: sourceIP, SourceID: sourceID, TemplateID: templateID}
+		storeTemplate(key, flowTemplate{
+			Fields:    fields,
+			ExpiresAt: time.Now().Add(templateTTL),
+		}, remoteAddr, outputChannel)
+	}
+}
+
+// handleFlowSets walks the FlowSets/Sets following a v9/IPFIX packet header
+// and dispatches each to template parsing or data decoding.
+func handleFlowSets(body []byte, sourceIP string, sourceID uint32, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	for len(body) >= 4 {
+		fsh := flowSetHeader{
+			ID:     binary.BigEndian.Uint16(body[0:2]),
+			Length: binary.BigEndian.Uint16(body[2:4]),
+		}
+		if fsh.Length < 4 || int(fsh.Length) > len(body) {
+			return
+		}
+		set := body[4:fsh.Length]
+		body = body[fsh.Length:]
+
+		switch fsh.ID {
+		case v9TemplateSetID, ipfixTemplateSetID:
+			parseTemplateSet(set, sourceIP, sourceID, remoteAddr, outputChannel)
+		case v9OptionsTemplateSetID, ipfixOptionsSetID:
+			// Options templates describe scope/meta records (e.g. sampler
+			// config) rather than flow data; not needed for the output
+			// schema we emit today, so we just skip them.
+		default:
+			key := templateKey{SourceIP: sourceIP, SourceID: sourceID, TemplateID: fsh.ID}
+			if tmpl, ok := lookupTemplate(key); ok {
+				decodeDataSet(tmpl, set, remoteAddr, outputChannel)
+			} else {
+				bufferPendingFlowSet(key, set)
+			}
+		}
+	}
+}
+
+func handlePacketV9(raw []byte, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	const v9HeaderLen = 20
+	if len(raw) < v9HeaderLen {
+		log.Printf("Error: short NetFlow v9 packet from %v\n", remoteAddr)
+		return
+	}
+	sourceID := binary.BigEndian.Uint32(raw[16:20])
+	handleFlowSets(raw[v9HeaderLen:], remoteAddr.IP.String(), sourceID, remoteAddr, outputChannel)
+}
+
+func handlePacketIPFIX(raw []byte, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	const ipfixHeaderLen = 16
+	if len(raw) < ipfixHeaderLen {
+		log.Printf("Error: short IPFIX packet from %v\n", remoteAddr)
+		return
+	}
+	observationDomainID := binary.BigEndian.Uint32(raw[12:16])
+	length := int(binary.BigEndian.Uint16(raw[2:4]))
+	if length > len(raw) {
+		length = len(raw)
+	}
+	handleFlowSets(raw[ipfixHeaderLen:length], remoteAddr.IP.String(), observationDomainID, remoteAddr, outputChannel)
+}
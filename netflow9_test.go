@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadVariableLength(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     []byte
+		offset   int
+		wantLen  int
+		wantNext int
+		wantOK   bool
+	}{
+		{"short form", []byte{3, 'a', 'b', 'c'}, 0, 3, 1, true},
+		{"long form", append([]byte{0xFF, 0x01, 0x00}, make([]byte, 256)...), 0, 256, 3, true},
+		{"truncated prefix", []byte{}, 0, 0, 0, false},
+		{"truncated long form", []byte{0xFF, 0x00}, 0, 0, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			length, next, ok := readVariableLength(c.data, c.offset)
+			if ok != c.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, c.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if length != c.wantLen || next != c.wantNext {
+				t.Fatalf("got (%d, %d), want (%d, %d)", length, next, c.wantLen, c.wantNext)
+			}
+		})
+	}
+}
+
+func TestDecodeTemplateRecordVariableLength(t *testing.T) {
+	const ieUnknownVariable = 9999
+	tmpl := flowTemplate{
+		Fields: []templateField{
+			{Type: ieProtocol, Length: 1},
+			{Type: ieUnknownVariable, Length: ieVariableLength},
+		},
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+
+	data := []byte{6, 3, 'x', 'y', 'z'}
+	remote := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 4739}
+
+	record, consumed, ok := decodeTemplateRecord(tmpl, data, remote)
+	if !ok {
+		t.Fatal("decodeTemplateRecord failed, want success")
+	}
+	if consumed != len(data) {
+		t.Fatalf("consumed = %d, want %d", consumed, len(data))
+	}
+	if record.Protocol != 6 {
+		t.Fatalf("Protocol = %d, want 6", record.Protocol)
+	}
+	if got, ok := record.Fields[fieldName(ieUnknownVariable)]; !ok || got != uint64(0x78797a) {
+		t.Fatalf("Fields[%q] = %v, want 0x78797a", fieldName(ieUnknownVariable), got)
+	}
+}
+
+func TestDecodeTemplateRecordTruncatedVariableLength(t *testing.T) {
+	const ieUnknownVariable = 9999
+	tmpl := flowTemplate{
+		Fields: []templateField{
+			{Type: ieUnknownVariable, Length: ieVariableLength},
+		},
+	}
+	// Prefix claims 5 bytes of value but only 2 are present.
+	data := []byte{5, 'a', 'b'}
+	remote := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 4739}
+
+	if _, _, ok := decodeTemplateRecord(tmpl, data, remote); ok {
+		t.Fatal("decodeTemplateRecord succeeded on truncated variable-length record, want failure")
+	}
+}
+
+func TestParseTemplateSetAndDecodeDataSet(t *testing.T) {
+	const templateID = 300
+	const sourceID = 1
+	sourceIP := "203.0.113.5"
+	remote := &net.UDPAddr{IP: net.ParseIP(sourceIP), Port: 2055}
+	outputChannel := make(chan decodedRecord, 1)
+
+	// Template: ieIPv4SrcAddr(4), ieL4SrcPort(2).
+	templateBody := make([]byte, 0, 12)
+	templateBody = binary.BigEndian.AppendUint16(templateBody, templateID)
+	templateBody = binary.BigEndian.AppendUint16(templateBody, 2)
+	templateBody = binary.BigEndian.AppendUint16(templateBody, ieIPv4SrcAddr)
+	templateBody = binary.BigEndian.AppendUint16(templateBody, 4)
+	templateBody = binary.BigEndian.AppendUint16(templateBody, ieL4SrcPort)
+	templateBody = binary.BigEndian.AppendUint16(templateBody, 2)
+
+	parseTemplateSet(templateBody, sourceIP, sourceID, remote, outputChannel)
+
+	key := templateKey{SourceIP: sourceIP, SourceID: sourceID, TemplateID: templateID}
+	tmpl, ok := lookupTemplate(key)
+	if !ok {
+		t.Fatal("template was not cached after parseTemplateSet")
+	}
+
+	data := []byte{192, 0, 2, 1, 0x1F, 0x90} // 192.0.2.1, port 8080
+	decodeDataSet(tmpl, data, remote, outputChannel)
+
+	select {
+	case record := <-outputChannel:
+		if record.Ipv4SrcAddr != "192.0.2.1" {
+			t.Fatalf("Ipv4SrcAddr = %q, want 192.0.2.1", record.Ipv4SrcAddr)
+		}
+		if record.L4SrcPort != 8080 {
+			t.Fatalf("L4SrcPort = %d, want 8080", record.L4SrcPort)
+		}
+	default:
+		t.Fatal("decodeDataSet did not emit a record")
+	}
+}
+
+func TestLookupTemplateExpired(t *testing.T) {
+	key := templateKey{SourceIP: "198.51.100.9", SourceID: 7, TemplateID: 42}
+	templateMutex.Lock()
+	templateCache[key] = flowTemplate{ExpiresAt: time.Now().Add(-time.Second)}
+	templateMutex.Unlock()
+	defer func() {
+		templateMutex.Lock()
+		delete(templateCache, key)
+		templateMutex.Unlock()
+	}()
+
+	if _, ok := lookupTemplate(key); ok {
+		t.Fatal("lookupTemplate returned an expired template as valid")
+	}
+}
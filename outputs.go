@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Output is the common sink interface all backends implement, so the
+// decode pipeline can fan a single decodedRecord stream out to any
+// combination of stdout, UDP line-protocol, Kafka, InfluxDB v2 and a
+// rotating NDJSON file.
+type Output interface {
+	Write(record decodedRecord) error
+	Flush() error
+	Close() error
+}
+
+// namedOutput tags an Output with the sink name it was configured under,
+// so errors can be attributed to a sink in both logs and metrics.
+type namedOutput struct {
+	name   string
+	output Output
+}
+
+// fanOutOutput forwards every record to each configured Output. A write
+// error on one sink is logged but does not stop delivery to the others.
+type fanOutOutput struct {
+	outputs []namedOutput
+}
+
+func (f *fanOutOutput) Write(record decodedRecord) error {
+	for _, o := range f.outputs {
+		if err := o.output.Write(record); err != nil {
+			log.Printf("Output write error (%s): %v\n", o.name, err)
+			outputSendErrorsTotal.WithLabelValues(o.name).Inc()
+		}
+	}
+	return nil
+}
+
+func (f *fanOutOutput) Flush() error {
+	for _, o := range f.outputs {
+		if err := o.output.Flush(); err != nil {
+			log.Printf("Output flush error (%s): %v\n", o.name, err)
+		}
+	}
+	return nil
+}
+
+func (f *fanOutOutput) Close() error {
+	for _, o := range f.outputs {
+		if err := o.output.Close(); err != nil {
+			log.Printf("Output close error (%s): %v\n", o.name, err)
+		}
+	}
+	return nil
+}
+
+// stdoutOutput prints each record as a JSON line.
+type stdoutOutput struct{}
+
+func (stdoutOutput) Write(record decodedRecord) error {
+	out, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func (stdoutOutput) Flush() error { return nil }
+func (stdoutOutput) Close() error { return nil }
+
+// runOutputLoop drains outputChannel into the given Output until the
+// channel is closed, logging (but not stopping on) write errors.
+func runOutputLoop(outputChannel chan decodedRecord, output Output) {
+	for record := range outputChannel {
+		if err := output.Write(record); err != nil {
+			log.Printf("Output write error: %v\n", err)
+		}
+	}
+}
+
+// outputConfig carries every flag needed to construct any combination of
+// output sinks, so main can stay a thin wrapper around buildOutputs.
+type outputConfig struct {
+	methods string
+
+	udpDestination string
+
+	kafkaBrokers string
+	kafkaTopic   string
+
+	influxURL    string
+	influxOrg    string
+	influxBucket string
+	influxToken  string
+
+	filePath       string
+	fileMaxSizeMB  int
+	fileMaxAgeDays int
+}
+
+// buildOutputs turns the comma-separated -method flag into a fanOutOutput
+// driving every requested sink simultaneously, e.g. "stdout,kafka,file".
+func buildOutputs(cfg outputConfig) *fanOutOutput {
+	fanout := &fanOutOutput{}
+
+	for _, method := range strings.Split(cfg.methods, ",") {
+		name := strings.TrimSpace(method)
+		switch name {
+		case "stdout":
+			fanout.outputs = append(fanout.outputs, namedOutput{name, stdoutOutput{}})
+		case "udp":
+			out, err := newUDPOutput(cfg.udpDestination)
+			if err != nil {
+				log.Fatalf("udp output: %v\n", err)
+			}
+			fanout.outputs = append(fanout.outputs, namedOutput{name, out})
+		case "kafka":
+			brokers := strings.Split(cfg.kafkaBrokers, ",")
+			fanout.outputs = append(fanout.outputs, namedOutput{name, newKafkaOutput(brokers, cfg.kafkaTopic)})
+		case "influxv2":
+			fanout.outputs = append(fanout.outputs, namedOutput{name, newInfluxV2Output(cfg.influxURL, cfg.influxOrg, cfg.influxBucket, cfg.influxToken)})
+		case "file":
+			fanout.outputs = append(fanout.outputs, namedOutput{name, newFileOutput(cfg.filePath, cfg.fileMaxSizeMB, cfg.fileMaxAgeDays)})
+		default:
+			log.Fatalf("Unknown output method: %v\n", method)
+		}
+	}
+
+	return fanout
+}
+
+// watchShutdownSignals flushes and closes every output sink before the
+// process exits on SIGINT/SIGTERM, so batched-but-unflushed records (e.g.
+// an influxv2 sink below its batch size) aren't lost on a clean shutdown.
+func watchShutdownSignals(output Output) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		log.Printf("Received %v, flushing outputs and exiting\n", s)
+		if err := output.Flush(); err != nil {
+			log.Printf("Shutdown flush error: %v\n", err)
+		}
+		if err := output.Close(); err != nil {
+			log.Printf("Shutdown close error: %v\n", err)
+		}
+		os.Exit(0)
+	}()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// fileOutput writes one NDJSON record per line to a local file, for feeding
+// a cold archive alongside a live metrics backend. The file is rotated once
+// it reaches maxSizeMB; rotated files are compressed and deleted after
+// maxAgeDays. Rotation is by size only, not on a time schedule.
+type fileOutput struct {
+	mu     sync.Mutex
+	logger *lumberjack.Logger
+}
+
+func newFileOutput(path string, maxSizeMB, maxAgeDays int) *fileOutput {
+	return &fileOutput{
+		logger: &lumberjack.Logger{
+			Filename: path,
+			MaxSize:  maxSizeMB,
+			MaxAge:   maxAgeDays,
+			Compress: true,
+		},
+	}
+}
+
+func (f *fileOutput) Write(record decodedRecord) error {
+	out, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.logger.Write(out)
+	return err
+}
+
+func (f *fileOutput) Flush() error { return nil }
+func (f *fileOutput) Close() error { return f.logger.Close() }
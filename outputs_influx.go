@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// influxV2Output batches line-protocol points and ships them gzip-encoded
+// to InfluxDB v2's HTTP write API, authenticating with a token rather than
+// the v1 username/password UDP approach.
+type influxV2Output struct {
+	url   string
+	token string
+
+	client *http.Client
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	points int
+
+	stopFlusher chan struct{}
+}
+
+const (
+	influxV2BatchSize     = 500
+	influxV2FlushInterval = 5 * time.Second
+)
+
+func newInfluxV2Output(baseURL, org, bucket, token string) *influxV2Output {
+	i := &influxV2Output{
+		url:         fmt.Sprintf("%s/api/v2/write?org=%s&bucket=%s&precision=ns", baseURL, org, bucket),
+		token:       token,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		stopFlusher: make(chan struct{}),
+	}
+	go i.runPeriodicFlush()
+	return i
+}
+
+// runPeriodicFlush flushes on a timer so a low-volume exporter doesn't sit
+// short of influxV2BatchSize points indefinitely; Write only flushes once
+// the batch fills up.
+func (i *influxV2Output) runPeriodicFlush() {
+	ticker := time.NewTicker(influxV2FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := i.Flush(); err != nil {
+				log.Printf("InfluxDB periodic flush error: %v\n", err)
+			}
+		case <-i.stopFlusher:
+			return
+		}
+	}
+}
+
+func (i *influxV2Output) Write(record decodedRecord) error {
+	i.mu.Lock()
+	i.buf.Write(formatLineProtocol(record))
+	i.buf.WriteByte('\n')
+	i.points++
+	shouldFlush := i.points >= influxV2BatchSize
+	i.mu.Unlock()
+
+	if shouldFlush {
+		return i.Flush()
+	}
+	return nil
+}
+
+func (i *influxV2Output) Flush() error {
+	i.mu.Lock()
+	if i.points == 0 {
+		i.mu.Unlock()
+		return nil
+	}
+	payload := make([]byte, i.buf.Len())
+	copy(payload, i.buf.Bytes())
+	i.buf.Reset()
+	i.points = 0
+	i.mu.Unlock()
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(payload); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, i.url, &gz)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+i.token)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("influxdb write failed: %v", resp.Status)
+	}
+	return nil
+}
+
+func (i *influxV2Output) Close() error {
+	close(i.stopFlusher)
+	return i.Flush()
+}
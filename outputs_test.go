@@ -0,0 +1,131 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeOutput records every call made to it, optionally failing writes, so
+// fanOutOutput's fan-out/error-isolation behavior can be tested without a
+// real sink.
+type fakeOutput struct {
+	writes  []decodedRecord
+	flushes int
+	closes  int
+	failAll bool
+}
+
+func (f *fakeOutput) Write(record decodedRecord) error {
+	f.writes = append(f.writes, record)
+	if f.failAll {
+		return errors.New("write failed")
+	}
+	return nil
+}
+
+func (f *fakeOutput) Flush() error {
+	f.flushes++
+	return nil
+}
+
+func (f *fakeOutput) Close() error {
+	f.closes++
+	return nil
+}
+
+func TestFanOutOutputWriteContinuesAfterSinkError(t *testing.T) {
+	failing := &fakeOutput{failAll: true}
+	ok := &fakeOutput{}
+	fanout := &fanOutOutput{outputs: []namedOutput{
+		{name: "failing", output: failing},
+		{name: "ok", output: ok},
+	}}
+
+	record := decodedRecord{Host: "192.0.2.1"}
+	if err := fanout.Write(record); err != nil {
+		t.Fatalf("fanOutOutput.Write returned %v, want nil (errors are logged, not propagated)", err)
+	}
+	if len(failing.writes) != 1 || len(ok.writes) != 1 {
+		t.Fatalf("expected both sinks to receive the write, got failing=%d ok=%d", len(failing.writes), len(ok.writes))
+	}
+}
+
+func TestFanOutOutputFlushAndClose(t *testing.T) {
+	a := &fakeOutput{}
+	b := &fakeOutput{}
+	fanout := &fanOutOutput{outputs: []namedOutput{{name: "a", output: a}, {name: "b", output: b}}}
+
+	if err := fanout.Flush(); err != nil {
+		t.Fatalf("Flush() = %v, want nil", err)
+	}
+	if err := fanout.Close(); err != nil {
+		t.Fatalf("Close() = %v, want nil", err)
+	}
+	if a.flushes != 1 || b.flushes != 1 {
+		t.Fatalf("expected every sink flushed once, got a=%d b=%d", a.flushes, b.flushes)
+	}
+	if a.closes != 1 || b.closes != 1 {
+		t.Fatalf("expected every sink closed once, got a=%d b=%d", a.closes, b.closes)
+	}
+}
+
+func TestInfluxV2OutputFlushSendsGzippedAuthenticatedBatch(t *testing.T) {
+	var gotAuth, gotEncoding string
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotEncoding = r.Header.Get("Content-Encoding")
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("gzip.NewReader: %v", err)
+			return
+		}
+		gotBody, _ = io.ReadAll(gz)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	out := newInfluxV2Output(server.URL, "org", "bucket", "secret-token")
+	defer out.Close()
+
+	record := decodedRecord{Host: "192.0.2.1", Ipv4SrcAddr: "192.0.2.1", Ipv4DstAddr: "192.0.2.2"}
+	if err := out.Write(record); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := out.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	if gotAuth != "Token secret-token" {
+		t.Fatalf("Authorization header = %q, want %q", gotAuth, "Token secret-token")
+	}
+	if gotEncoding != "gzip" {
+		t.Fatalf("Content-Encoding header = %q, want gzip", gotEncoding)
+	}
+	if !strings.Contains(string(gotBody), "srcAddr=192.0.2.1") {
+		t.Fatalf("flushed body = %q, want it to contain the written record", gotBody)
+	}
+}
+
+func TestInfluxV2OutputFlushNoOpWhenEmpty(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	out := newInfluxV2Output(server.URL, "org", "bucket", "token")
+	defer out.Close()
+
+	if err := out.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if called {
+		t.Fatal("Flush made an HTTP request with no buffered points")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// udpOutput writes each record as a line-protocol datagram to a single
+// remote address. A failed send redials the target for the next record;
+// the failed record is not resent and Write returns its error.
+type udpOutput struct {
+	targetAddr string
+
+	mu   sync.Mutex
+	conn *net.UDPConn
+}
+
+func newUDPOutput(targetAddr string) (*udpOutput, error) {
+	conn, err := dialUDP(targetAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpOutput{targetAddr: targetAddr, conn: conn}, nil
+}
+
+func dialUDP(targetAddr string) (*net.UDPConn, error) {
+	remote, err := net.ResolveUDPAddr("udp", targetAddr)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", nil, remote)
+}
+
+func (u *udpOutput) Write(record decodedRecord) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.conn.SetDeadline(time.Now().Add(3 * time.Second))
+	_, err := u.conn.Write(formatLineProtocol(record))
+	if err != nil {
+		log.Printf("Send Error: %v\n", err)
+		if newConn, derr := dialUDP(u.targetAddr); derr == nil {
+			u.conn.Close()
+			u.conn = newConn
+		}
+	}
+	return err
+}
+
+func (u *udpOutput) Flush() error { return nil }
+
+func (u *udpOutput) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.conn.Close()
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+)
+
+// sFlow v5 (RFC-like, see sflow.org "sFlow Version 5") datagram parsing.
+//
+// An sFlow datagram carries one or more samples from an agent. We decode
+// flow samples (raw Ethernet/IPv4/IPv6/TCP/UDP headers) and counter
+// samples into the same decodedRecord stream used by the NetFlow path, so
+// both protocols feed the same Output sinks (see outputs.go).
+
+const (
+	sflowVersion = 5
+
+	sflowFormatFlowSample           = 1
+	sflowFormatCountersSample       = 2
+	sflowFormatFlowSampleExpanded   = 3
+	sflowFormatCountersSampleExpand = 4
+
+	sflowFlowRecordRawPacketHeader = 1
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86DD
+	etherTypeVLAN = 0x8100
+)
+
+// looksLikeSFlow peeks at the datagram without consuming it, so -protocol
+// auto can tell it apart from a NetFlow packet before committing to a
+// decoder.
+func looksLikeSFlow(raw []byte) bool {
+	if len(raw) < 8 {
+		return false
+	}
+	if binary.BigEndian.Uint32(raw[0:4]) != sflowVersion {
+		return false
+	}
+	addrType := binary.BigEndian.Uint32(raw[4:8])
+	return addrType == 1 || addrType == 2
+}
+
+func handlePacketSFlow(raw []byte, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	offset := 4 // version already checked by looksLikeSFlow
+
+	addrType := binary.BigEndian.Uint32(raw[offset : offset+4])
+	offset += 4
+
+	var agentAddr net.IP
+	switch addrType {
+	case 1:
+		if len(raw) < offset+4 {
+			log.Printf("Error: truncated sFlow agent address (IPv4) from %v\n", remoteAddr)
+			decodeErrorsTotal.WithLabelValues("sflow_agent_addr").Inc()
+			return
+		}
+		agentAddr = net.IP(raw[offset : offset+4])
+		offset += 4
+	case 2:
+		if len(raw) < offset+16 {
+			log.Printf("Error: truncated sFlow agent address (IPv6) from %v\n", remoteAddr)
+			decodeErrorsTotal.WithLabelValues("sflow_agent_addr").Inc()
+			return
+		}
+		agentAddr = net.IP(raw[offset : offset+16])
+		offset += 16
+	default:
+		log.Printf("Error: unsupported sFlow agent address type %d from %v\n", addrType, remoteAddr)
+		decodeErrorsTotal.WithLabelValues("sflow_agent_addr").Inc()
+		return
+	}
+
+	if len(raw) < offset+16 {
+		log.Printf("Error: truncated sFlow datagram header from %v\n", remoteAddr)
+		decodeErrorsTotal.WithLabelValues("sflow_header").Inc()
+		return
+	}
+	offset += 4 // SubAgentID
+	offset += 4 // SequenceNumber
+	offset += 4 // SysUpTime
+	numSamples := binary.BigEndian.Uint32(raw[offset : offset+4])
+	offset += 4
+
+	for i := uint32(0); i < numSamples; i++ {
+		if len(raw) < offset+8 {
+			log.Printf("Error: truncated sFlow sample header from %v\n", remoteAddr)
+			decodeErrorsTotal.WithLabelValues("sflow_sample_header").Inc()
+			break
+		}
+		sampleType := binary.BigEndian.Uint32(raw[offset : offset+4])
+		sampleLength := binary.BigEndian.Uint32(raw[offset+4 : offset+8])
+		offset += 8
+
+		if len(raw) < offset+int(sampleLength) {
+			log.Printf("Error: truncated sFlow sample body from %v\n", remoteAddr)
+			decodeErrorsTotal.WithLabelValues("sflow_sample_body").Inc()
+			break
+		}
+		sample := raw[offset : offset+int(sampleLength)]
+		offset += int(sampleLength)
+
+		switch sampleType & 0xfff {
+		case sflowFormatFlowSample, sflowFormatFlowSampleExpanded:
+			decodeSFlowFlowSample(sample, sampleType&0xfff == sflowFormatFlowSampleExpanded, agentAddr, remoteAddr, outputChannel)
+		case sflowFormatCountersSample, sflowFormatCountersSampleExpand:
+			decodeSFlowCountersSample(sample, sampleType&0xfff == sflowFormatCountersSampleExpand, agentAddr, remoteAddr, outputChannel)
+		}
+	}
+}
+
+func decodeSFlowFlowSample(sample []byte, expanded bool, agentAddr net.IP, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	// Common header: SequenceNumber, SourceID, SamplingRate, SamplePool,
+	// Drops, Input, Output, FlowRecordsCount are all uint32. The expanded
+	// form widens SourceID/Input/Output to (type uint32, index uint32)
+	// pairs; we only need the sampling rate and record count here.
+	headerLen := 32
+	samplingRateOffset := 8
+	if expanded {
+		headerLen = 44
+		samplingRateOffset = 12
+	}
+	if len(sample) < headerLen {
+		log.Printf("Error: truncated sFlow flow sample header from %v\n", remoteAddr)
+		decodeErrorsTotal.WithLabelValues("sflow_flow_sample_header").Inc()
+		return
+	}
+	samplingRate := binary.BigEndian.Uint32(sample[samplingRateOffset : samplingRateOffset+4])
+	flowRecordsCount := binary.BigEndian.Uint32(sample[headerLen-4 : headerLen])
+	offset := headerLen
+
+	for i := uint32(0); i < flowRecordsCount; i++ {
+		if len(sample) < offset+8 {
+			log.Printf("Error: truncated sFlow flow record header from %v\n", remoteAddr)
+			decodeErrorsTotal.WithLabelValues("sflow_flow_record_header").Inc()
+			return
+		}
+		recordType := binary.BigEndian.Uint32(sample[offset : offset+4])
+		recordLength := binary.BigEndian.Uint32(sample[offset+4 : offset+8])
+		offset += 8
+		if len(sample) < offset+int(recordLength) {
+			log.Printf("Error: truncated sFlow flow record body from %v\n", remoteAddr)
+			decodeErrorsTotal.WithLabelValues("sflow_flow_record_body").Inc()
+			return
+		}
+		recordData := sample[offset : offset+int(recordLength)]
+		offset += int(recordLength)
+
+		if recordType&0xfff != sflowFlowRecordRawPacketHeader {
+			continue
+		}
+
+		record, ok := decodeSFlowRawPacketHeader(recordData)
+		if !ok {
+			log.Printf("Error: truncated sFlow raw packet header from %v\n", remoteAddr)
+			decodeErrorsTotal.WithLabelValues("sflow_raw_packet_header").Inc()
+			continue
+		}
+		record.Host = remoteAddr.IP.String()
+		record.Agent = agentAddr.String()
+		record.SampleType = "flow"
+		record.SamplingRate = samplingRate
+		record.SrcHostName = lookUpWithCache(record.Ipv4SrcAddr)
+		record.DstHostName = lookUpWithCache(record.Ipv4DstAddr)
+		flowRecordsDecodedTotal.WithLabelValues("sflow").Inc()
+		outputChannel <- record
+	}
+}
+
+// decodeSFlowRawPacketHeader parses a raw_packet_header flow record
+// (header protocol, frame/stripped/header length, then the captured
+// header bytes) and pulls out the Ethernet/IPv4/IPv6/TCP/UDP fields we
+// report on.
+func decodeSFlowRawPacketHeader(data []byte) (decodedRecord, bool) {
+	if len(data) < 16 {
+		return decodedRecord{}, false
+	}
+	frameLength := binary.BigEndian.Uint32(data[4:8])
+	headerLength := binary.BigEndian.Uint32(data[12:16])
+	header := data[16:]
+	if uint32(len(header)) > headerLength {
+		header = header[:headerLength]
+	}
+
+	record := decodedRecord{
+		binaryRecord: binaryRecord{InBytes: frameLength, InPkts: 1},
+		Fields:       map[string]interface{}{},
+	}
+
+	if len(header) < 14 {
+		return record, true
+	}
+	etherType := binary.BigEndian.Uint16(header[12:14])
+	payload := header[14:]
+	if etherType == etherTypeVLAN && len(payload) >= 4 {
+		etherType = binary.BigEndian.Uint16(payload[2:4])
+		payload = payload[4:]
+	}
+
+	switch etherType {
+	case etherTypeIPv4:
+		decodeSFlowIPv4(payload, &record)
+	case etherTypeIPv6:
+		decodeSFlowIPv6(payload, &record)
+	}
+
+	return record, true
+}
+
+func decodeSFlowIPv4(payload []byte, record *decodedRecord) {
+	if len(payload) < 20 {
+		return
+	}
+	ihl := int(payload[0]&0x0f) * 4
+	record.Protocol = payload[9]
+	record.Ipv4SrcAddr = net.IP(payload[12:16]).String()
+	record.Ipv4DstAddr = net.IP(payload[16:20]).String()
+
+	if ihl < 20 || len(payload) < ihl+4 {
+		return
+	}
+	decodeSFlowL4Ports(payload[ihl:], record)
+}
+
+func decodeSFlowIPv6(payload []byte, record *decodedRecord) {
+	if len(payload) < 40 {
+		return
+	}
+	record.Protocol = payload[6]
+	record.Ipv4SrcAddr = net.IP(payload[8:24]).String()
+	record.Ipv4DstAddr = net.IP(payload[24:40]).String()
+
+	if len(payload) < 44 {
+		return
+	}
+	decodeSFlowL4Ports(payload[40:], record)
+}
+
+func decodeSFlowL4Ports(l4 []byte, record *decodedRecord) {
+	if len(l4) < 4 {
+		return
+	}
+	record.L4SrcPort = binary.BigEndian.Uint16(l4[0:2])
+	record.L4DstPort = binary.BigEndian.Uint16(l4[2:4])
+}
+
+func decodeSFlowCountersSample(sample []byte, expanded bool, agentAddr net.IP, remoteAddr *net.UDPAddr, outputChannel chan decodedRecord) {
+	// Common header: SequenceNumber, SourceID, CountersRecordsCount. The
+	// expanded form widens SourceID to a (type, index) pair.
+	headerLen := 12
+	if expanded {
+		headerLen = 16
+	}
+	if len(sample) < headerLen {
+		log.Printf("Error: truncated sFlow counters sample header from %v\n", remoteAddr)
+		decodeErrorsTotal.WithLabelValues("sflow_counters_sample_header").Inc()
+		return
+	}
+	recordsCount := binary.BigEndian.Uint32(sample[headerLen-4 : headerLen])
+	offset := headerLen
+
+	record := decodedRecord{
+		Host:       remoteAddr.IP.String(),
+		Agent:      agentAddr.String(),
+		SampleType: "counters",
+		Fields:     map[string]interface{}{},
+	}
+
+	for i := uint32(0); i < recordsCount; i++ {
+		if len(sample) < offset+8 {
+			log.Printf("Error: truncated sFlow counters record header from %v\n", remoteAddr)
+			decodeErrorsTotal.WithLabelValues("sflow_counters_record_header").Inc()
+			break
+		}
+		recordType := binary.BigEndian.Uint32(sample[offset : offset+4])
+		recordLength := binary.BigEndian.Uint32(sample[offset+4 : offset+8])
+		offset += 8
+		if len(sample) < offset+int(recordLength) {
+			log.Printf("Error: truncated sFlow counters record body from %v\n", remoteAddr)
+			decodeErrorsTotal.WithLabelValues("sflow_counters_record_body").Inc()
+			break
+		}
+		recordData := sample[offset : offset+int(recordLength)]
+		offset += int(recordLength)
+
+		// Generic interface counters (type 1): ifIndex(4), ifType(4),
+		// ifSpeed(8), ifDirection(4), ifStatus(4), ifInOctets(8), ...
+		if recordType&0xfff == 1 && len(recordData) >= 32 {
+			record.Fields["ifIndex"] = binary.BigEndian.Uint32(recordData[0:4])
+			record.Fields["ifInOctets"] = binary.BigEndian.Uint64(recordData[24:32])
+		}
+	}
+
+	flowRecordsDecodedTotal.WithLabelValues("sflow_counters").Inc()
+	outputChannel <- record
+}
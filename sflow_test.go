@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestLooksLikeSFlow(t *testing.T) {
+	sflowPacket := make([]byte, 8)
+	binary.BigEndian.PutUint32(sflowPacket[0:4], sflowVersion)
+	binary.BigEndian.PutUint32(sflowPacket[4:8], 1)
+	if !looksLikeSFlow(sflowPacket) {
+		t.Fatal("looksLikeSFlow returned false for a valid sFlow header")
+	}
+
+	netflowPacket := make([]byte, 8)
+	binary.BigEndian.PutUint16(netflowPacket[0:2], 5)
+	if looksLikeSFlow(netflowPacket) {
+		t.Fatal("looksLikeSFlow returned true for a NetFlow v5 header")
+	}
+
+	if looksLikeSFlow([]byte{0, 0, 0}) {
+		t.Fatal("looksLikeSFlow returned true for a too-short packet")
+	}
+}
+
+// buildEthIPv4TCPHeader builds a captured-header byte slice (Ethernet +
+// IPv4 + TCP, no VLAN tag) as carried inside an sFlow raw_packet_header
+// flow record.
+func buildEthIPv4TCPHeader(srcIP, dstIP [4]byte, srcPort, dstPort uint16) []byte {
+	header := make([]byte, 14+20+4)
+	binary.BigEndian.PutUint16(header[12:14], etherTypeIPv4)
+
+	ip := header[14:]
+	ip[0] = 0x45 // version 4, IHL 5 (20 bytes)
+	ip[9] = 6    // TCP
+	copy(ip[12:16], srcIP[:])
+	copy(ip[16:20], dstIP[:])
+
+	tcp := ip[20:]
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+
+	return header
+}
+
+func TestDecodeSFlowRawPacketHeader(t *testing.T) {
+	captured := buildEthIPv4TCPHeader([4]byte{192, 0, 2, 1}, [4]byte{192, 0, 2, 2}, 51000, 443)
+
+	data := make([]byte, 16+len(captured))
+	binary.BigEndian.PutUint32(data[4:8], 128)                     // frame length
+	binary.BigEndian.PutUint32(data[12:16], uint32(len(captured))) // header length
+	copy(data[16:], captured)
+
+	record, ok := decodeSFlowRawPacketHeader(data)
+	if !ok {
+		t.Fatal("decodeSFlowRawPacketHeader failed, want success")
+	}
+	if record.InBytes != 128 {
+		t.Fatalf("InBytes = %d, want 128", record.InBytes)
+	}
+	if record.InPkts != 1 {
+		t.Fatalf("InPkts = %d, want 1", record.InPkts)
+	}
+	if record.Ipv4SrcAddr != "192.0.2.1" {
+		t.Fatalf("Ipv4SrcAddr = %q, want 192.0.2.1", record.Ipv4SrcAddr)
+	}
+	if record.Ipv4DstAddr != "192.0.2.2" {
+		t.Fatalf("Ipv4DstAddr = %q, want 192.0.2.2", record.Ipv4DstAddr)
+	}
+	if record.Protocol != 6 {
+		t.Fatalf("Protocol = %d, want 6", record.Protocol)
+	}
+	if record.L4SrcPort != 51000 || record.L4DstPort != 443 {
+		t.Fatalf("ports = %d/%d, want 51000/443", record.L4SrcPort, record.L4DstPort)
+	}
+}
+
+func TestDecodeSFlowRawPacketHeaderTooShort(t *testing.T) {
+	if _, ok := decodeSFlowRawPacketHeader([]byte{1, 2, 3}); ok {
+		t.Fatal("decodeSFlowRawPacketHeader succeeded on a too-short record")
+	}
+}
+
+func TestDecodeSFlowIPv4NoL4(t *testing.T) {
+	record := decodedRecord{}
+	// IHL 5 (20 bytes), no payload beyond the header.
+	payload := make([]byte, 20)
+	payload[0] = 0x45
+	payload[9] = 17 // UDP
+	copy(payload[12:16], []byte{10, 0, 0, 1})
+	copy(payload[16:20], []byte{10, 0, 0, 2})
+
+	decodeSFlowIPv4(payload, &record)
+
+	if record.Protocol != 17 {
+		t.Fatalf("Protocol = %d, want 17", record.Protocol)
+	}
+	if record.L4SrcPort != 0 || record.L4DstPort != 0 {
+		t.Fatalf("ports = %d/%d, want 0/0 when no L4 payload is present", record.L4SrcPort, record.L4DstPort)
+	}
+}